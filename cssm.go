@@ -1,4 +1,11 @@
 // Package cssm provides scoped CSS for gomponents.
+//
+// Collector is safe for concurrent use: Classes, ClassesFS, C, A, R, and
+// Render may all be called from multiple goroutines. For the common case of
+// a fixed set of component stylesheets registered once at startup and then
+// read from many request goroutines, call (*Collector).Freeze to obtain a
+// Stylesheet: an immutable snapshot with the same C, A, R, and Render API
+// but no locking.
 package cssm
 
 import (
@@ -8,14 +15,144 @@ import (
 	"fmt"
 	"hash/adler32"
 	"io"
+	"io/fs"
+	"path"
 	"strings"
+	"sync"
 
 	"github.com/maragudk/gomponents"
 	h "github.com/maragudk/gomponents/html"
+	"github.com/tdewolff/minify/v2"
+	minifycss "github.com/tdewolff/minify/v2/css"
 	"github.com/tdewolff/parse/v2"
 	"github.com/tdewolff/parse/v2/css"
 )
 
+// cssMinify is shared by canonicalizeCSS and minifyCSS; tdewolff/minify's M
+// is safe for concurrent use once its minifier funcs are registered.
+var cssMinify = newCSSMinify()
+
+func newCSSMinify() *minify.M {
+	m := minify.New()
+	m.AddFunc("text/css", minifycss.Minify)
+	return m
+}
+
+// canonicalizeCSS returns a whitespace- and comment-normalized form of
+// styles. Collector uses it, when Minify is enabled, so that rulesets which
+// differ only cosmetically share one scoped hash, one cache entry, and one
+// emitted style block.
+func canonicalizeCSS(styles string) (string, error) {
+	return minifyCSSLayerAware(styles)
+}
+
+// minifyCSS minifies already-scoped CSS bytes before they're appended to a
+// Collector's rendered styles.
+func minifyCSS(styles []byte) ([]byte, error) {
+	minified, err := minifyCSSLayerAware(string(styles))
+	if err != nil {
+		return nil, err
+	}
+	return []byte(minified), nil
+}
+
+// minifyCSSLayerAware minifies styles, working around tdewolff/minify's
+// `@layer name { ... }` handling: it passes the body of a layer block
+// through byte-for-byte rather than minifying it. minifyCSSLayerAware
+// carves out any `@layer` blocks first and recurses into their contents
+// (nested `@layer`s included), running the minifier over the prelude and
+// every stretch of non-layer text instead of the whole input at once.
+func minifyCSSLayerAware(styles string) (string, error) {
+	in := make([]byte, len(styles), len(styles)+1)
+	copy(in, styles)
+	zz := css.NewLexer(parse.NewInputBytes(in))
+
+	var out, segment strings.Builder
+	flush := func() error {
+		if segment.Len() == 0 {
+			return nil
+		}
+		minified, err := cssMinify.String("text/css", segment.String())
+		if err != nil {
+			return err
+		}
+		out.WriteString(minified)
+		segment.Reset()
+		return nil
+	}
+
+	for {
+		zt, data := zz.Next()
+		if zt == css.ErrorToken {
+			break
+		}
+		if zt != css.AtKeywordToken || string(data) != "@layer" {
+			segment.Write(data)
+			continue
+		}
+
+		var prelude strings.Builder
+		prelude.WriteString("@layer ")
+		isBlock := false
+	preludeLoop:
+		for {
+			zt, data := zz.Next()
+			switch zt {
+			case css.ErrorToken, css.SemicolonToken:
+				break preludeLoop
+			case css.LeftBraceToken:
+				isBlock = true
+				break preludeLoop
+			default:
+				prelude.Write(data)
+			}
+		}
+		minPrelude, err := cssMinify.String("text/css", prelude.String()+";")
+		if err != nil {
+			return "", err
+		}
+
+		if !isBlock {
+			segment.WriteString(minPrelude)
+			segment.WriteByte(';')
+			continue
+		}
+
+		depth := 1
+		var inner strings.Builder
+		for depth > 0 {
+			zt, data := zz.Next()
+			if zt == css.ErrorToken {
+				break
+			}
+			if zt == css.LeftBraceToken {
+				depth++
+			} else if zt == css.RightBraceToken {
+				depth--
+				if depth == 0 {
+					break
+				}
+			}
+			inner.Write(data)
+		}
+		if err := flush(); err != nil {
+			return "", err
+		}
+		innerMinified, err := minifyCSSLayerAware(inner.String())
+		if err != nil {
+			return "", err
+		}
+		out.WriteString(minPrelude)
+		out.WriteByte('{')
+		out.WriteString(innerMinified)
+		out.WriteByte('}')
+	}
+	if err := flush(); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
 func genHash(in []byte) string {
 	// generate a hash for the entire ruleset. this scopes the rules with a
 	// deterministic hash that changes when any of the rules change.
@@ -26,15 +163,317 @@ func genHash(in []byte) string {
 	return base64.RawURLEncoding.EncodeToString(checksum)
 }
 
+// findScopedAnimations scans styles for top-level `@keyframes <ident>` names
+// ahead of the main pass, so `animation`/`animation-name` references can be
+// rewritten regardless of whether they appear before or after the
+// `@keyframes` block that defines them. Names declared inside a `:global`
+// block are skipped since they are emitted unscoped.
+func findScopedAnimations(styles string, hash string) map[string]string {
+	in := make([]byte, len(styles), len(styles)+1)
+	copy(in, styles)
+	zz := css.NewLexer(parse.NewInputBytes(in))
+	scopedAnimations := map[string]string{}
+
+scanLoop:
+	for {
+		zt, data := zz.Next()
+		if zt == css.ErrorToken {
+			return scopedAnimations
+		}
+
+		if zt == css.ColonToken {
+			zt, data := zz.Next()
+			if zt == css.ErrorToken || zt != css.IdentToken || string(data) != "global" {
+				continue scanLoop
+			}
+			braceCount := 0
+			for {
+				zt, _ := zz.Next()
+				if zt == css.ErrorToken {
+					continue scanLoop
+				}
+				if zt == css.LeftBraceToken {
+					braceCount++
+				} else if zt == css.RightBraceToken {
+					braceCount--
+					if braceCount <= 0 {
+						break
+					}
+				}
+			}
+		} else if zt == css.AtKeywordToken && string(data) == "@keyframes" {
+			for {
+				zt, data := zz.Next()
+				if zt == css.ErrorToken {
+					continue scanLoop
+				}
+				if zt == css.IdentToken {
+					name := string(data)
+					scopedAnimations[name] = name + "_" + hash
+					continue scanLoop
+				}
+			}
+		}
+	}
+}
+
+// composeKind distinguishes the three forms a `composes:` reference can take.
+type composeKind int
+
+const (
+	composeLocal composeKind = iota
+	composeGlobal
+	composeExternal
+)
+
+// composeRef is one class name referenced by a `composes:` declaration.
+type composeRef struct {
+	kind   composeKind
+	name   string
+	source string // set for composeExternal: the `from "<source>"` path
+}
+
+// findComposes scans styles for `composes:` declarations ahead of the main
+// pass, keyed by the raw class name of the rule the declaration appears in.
+// Names declared inside a `:global` block are skipped, since composition only
+// makes sense for scoped classes.
+func findComposes(styles string) map[string][]composeRef {
+	in := make([]byte, len(styles), len(styles)+1)
+	copy(in, styles)
+	zz := css.NewLexer(parse.NewInputBytes(in))
+	composed := map[string][]composeRef{}
+	currentClass := ""
+
+scanLoop:
+	for {
+		zt, data := zz.Next()
+		if zt == css.ErrorToken {
+			return composed
+		}
+
+		if zt == css.ColonToken {
+			zt, data := zz.Next()
+			if zt == css.ErrorToken || zt != css.IdentToken || string(data) != "global" {
+				continue scanLoop
+			}
+			braceCount := 0
+			for {
+				zt, _ := zz.Next()
+				if zt == css.ErrorToken {
+					continue scanLoop
+				}
+				if zt == css.LeftBraceToken {
+					braceCount++
+				} else if zt == css.RightBraceToken {
+					braceCount--
+					if braceCount <= 0 {
+						break
+					}
+				}
+			}
+		} else if zt == css.DelimToken && string(data) == "." {
+			zt, data := zz.Next()
+			if zt == css.ErrorToken {
+				continue scanLoop
+			}
+			if zt == css.IdentToken {
+				currentClass = string(data)
+			}
+		} else if zt == css.IdentToken && string(data) == "composes" {
+			refs := parseComposesValue(zz)
+			if currentClass != "" {
+				composed[currentClass] = append(composed[currentClass], refs...)
+			}
+		}
+	}
+}
+
+// parseComposesValue reads the value of a `composes:` declaration, up to and
+// including its terminating `;` or the rule's closing `}`.
+func parseComposesValue(zz *css.Lexer) []composeRef {
+	var names []string
+	source := ""
+	global := false
+	seenFrom := false
+
+	for {
+		zt, data := zz.Next()
+		if zt == css.ErrorToken {
+			break
+		}
+		switch zt {
+		case css.IdentToken:
+			name := string(data)
+			switch {
+			case name == "from":
+				seenFrom = true
+			case seenFrom && name == "global":
+				global = true
+			default:
+				names = append(names, name)
+			}
+		case css.StringToken:
+			source = strings.Trim(string(data), `"'`)
+		case css.SemicolonToken, css.RightBraceToken:
+			goto done
+		}
+	}
+done:
+	refs := make([]composeRef, 0, len(names))
+	for _, name := range names {
+		switch {
+		case global:
+			refs = append(refs, composeRef{kind: composeGlobal, name: name})
+		case source != "":
+			refs = append(refs, composeRef{kind: composeExternal, name: name, source: source})
+		default:
+			refs = append(refs, composeRef{kind: composeLocal, name: name})
+		}
+	}
+	return refs
+}
+
+// resolveComposed flattens each rule's composes references into the final
+// scoped (or, for `from global`, unscoped) class names, following local
+// composition transitively and detecting cycles.
+func resolveComposed(raw map[string][]composeRef, hash string) (map[string][]string, error) {
+	composed := map[string][]string{}
+	for name := range raw {
+		list, err := flattenComposed(name, hash, raw, map[string]bool{})
+		if err != nil {
+			return nil, err
+		}
+		composed[name] = list
+	}
+	return composed, nil
+}
+
+func flattenComposed(name, hash string, raw map[string][]composeRef, visited map[string]bool) ([]string, error) {
+	if visited[name] {
+		return nil, fmt.Errorf("cssm: composition cycle detected involving %q", name)
+	}
+	visited[name] = true
+	defer delete(visited, name)
+
+	var out []string
+	for _, ref := range raw[name] {
+		switch ref.kind {
+		case composeGlobal:
+			out = append(out, ref.name)
+		case composeExternal:
+			out = append(out, ref.name+"@"+ref.source)
+		default:
+			out = append(out, ref.name+"_"+hash)
+			nested, err := flattenComposed(ref.name, hash, raw, visited)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, nested...)
+		}
+	}
+	return out, nil
+}
+
+// ProcessErrorKind identifies the kind of malformed construct a ProcessError
+// was raised for.
+type ProcessErrorKind int
+
+const (
+	// KindUnterminatedBlock is a `:global { ... }` or `@media { ... }` block
+	// missing its closing brace.
+	KindUnterminatedBlock ProcessErrorKind = iota
+	// KindUnexpectedTokenInGlobal is a `:global` not immediately followed by
+	// its opening brace.
+	KindUnexpectedTokenInGlobal
+	// KindUnexpectedEOFInMedia is a `@media { ... }` block that runs into
+	// EOF before its closing brace.
+	KindUnexpectedEOFInMedia
+	// KindUnexpectedEOFInDeclaration is a `composes:`, `animation:`, or
+	// `animation-name:` declaration that runs into EOF before its
+	// terminating `;` or `}`.
+	KindUnexpectedEOFInDeclaration
+)
+
+func (k ProcessErrorKind) String() string {
+	switch k {
+	case KindUnterminatedBlock:
+		return "unterminated block"
+	case KindUnexpectedTokenInGlobal:
+		return "unexpected token inside :global"
+	case KindUnexpectedEOFInMedia:
+		return "unexpected EOF inside @media"
+	case KindUnexpectedEOFInDeclaration:
+		return "unexpected EOF inside declaration"
+	default:
+		return "unknown error"
+	}
+}
+
+// ProcessError describes a malformed construct encountered while processing
+// a stylesheet, located by line, column, and byte offset into the original
+// styles string.
+type ProcessError struct {
+	Line    int
+	Column  int
+	Offset  int
+	Snippet string
+	Kind    ProcessErrorKind
+}
+
+func (e ProcessError) Error() string {
+	return fmt.Sprintf("cssm: %s at line %d, column %d: %q", e.Kind, e.Line, e.Column, e.Snippet)
+}
+
+func newProcessError(styles string, offset int, kind ProcessErrorKind) ProcessError {
+	line, col, snippet := parse.Position(strings.NewReader(styles), offset)
+	return ProcessError{Line: line, Column: col, Offset: offset, Snippet: snippet, Kind: kind}
+}
+
+// ProcessOptions configures how ProcessWith handles malformed constructs.
+type ProcessOptions struct {
+	// Strict, when true, turns malformed constructs into a returned
+	// ProcessError instead of processing them leniently.
+	Strict bool
+	// Warnings, when non-nil, collects a ProcessError for each malformed
+	// construct encountered while Strict is false.
+	Warnings *[]ProcessError
+}
+
 // Parses the CSS and returns the CSS processed, the key-value pair of the
-// classes and scoped classes.
-func Process(styles string) ([]byte, map[string]string, error) {
+// classes and scoped classes, the key-value pair of the animation names and
+// scoped animation names, and the key-value pair of each class and the
+// (already scoped, where resolvable) class names it composes.
+func Process(styles string) ([]byte, map[string]string, map[string]string, map[string][]string, error) {
+	return ProcessWith(styles, ProcessOptions{})
+}
+
+// ProcessWith is like Process but accepts ProcessOptions controlling how
+// malformed constructs (an unterminated `:global` or `@media` block, a
+// truncated declaration, etc.) are handled: see ProcessOptions.Strict.
+func ProcessWith(styles string, opts ProcessOptions) ([]byte, map[string]string, map[string]string, map[string][]string, error) {
 	// per the docs in NewInputBytes, leave room for a null byte
 	in := make([]byte, len(styles), len(styles)+1)
 	copy(in, styles)
 	hash := genHash(in)
-	zz := css.NewLexer(parse.NewInputBytes(in))
+	input := parse.NewInputBytes(in)
+	zz := css.NewLexer(input)
 	scopedClasses := map[string]string{}
+	scopedAnimations := findScopedAnimations(styles, hash)
+	composed, err := resolveComposed(findComposes(styles), hash)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+
+	reportIssue := func(kind ProcessErrorKind) error {
+		pe := newProcessError(styles, input.Offset(), kind)
+		if opts.Strict {
+			return pe
+		}
+		if opts.Warnings != nil {
+			*opts.Warnings = append(*opts.Warnings, pe)
+		}
+		return nil
+	}
 
 	var out bytes.Buffer
 	var tmp bytes.Buffer
@@ -46,14 +485,23 @@ func Process(styles string) ([]byte, map[string]string, error) {
 		out.WriteString(scopedName)
 	}
 
+	writeAnimationName := func(rawNameBytes []byte) {
+		rawName := string(rawNameBytes)
+		if scopedName, found := scopedAnimations[rawName]; found {
+			out.WriteString(scopedName)
+		} else {
+			out.Write(rawNameBytes)
+		}
+	}
+
 mainLoop:
 	for {
 		zt, data := zz.Next()
 		if zt == css.ErrorToken {
 			if err := zz.Err(); err == io.EOF {
-				return out.Bytes(), scopedClasses, nil
+				return out.Bytes(), scopedClasses, scopedAnimations, composed, nil
 			} else if err != nil {
-				return nil, nil, err
+				return nil, nil, nil, nil, err
 			}
 		}
 
@@ -75,11 +523,23 @@ mainLoop:
 				continue mainLoop
 			}
 			braceCount := 0
+			first := true
 			for {
 				zt, data := zz.Next()
 				if zt == css.ErrorToken {
+					if err := reportIssue(KindUnterminatedBlock); err != nil {
+						return nil, nil, nil, nil, err
+					}
 					continue mainLoop
 				}
+				if first && zt != css.WhitespaceToken {
+					first = false
+					if zt != css.LeftBraceToken {
+						if err := reportIssue(KindUnexpectedTokenInGlobal); err != nil {
+							return nil, nil, nil, nil, err
+						}
+					}
+				}
 				if zt == css.LeftBraceToken {
 					if braceCount != 0 {
 						out.Write(data)
@@ -97,30 +557,37 @@ mainLoop:
 					out.Write(data)
 				}
 			}
-		} else if zt == css.AtKeywordToken {
+		} else if zt == css.AtKeywordToken && string(data) == "@keyframes" {
 			if _, err := tmp.WriteTo(&out); err != nil {
-				return nil, nil, err
-			}
-			zt, data := zz.Next()
-			if zt == css.ErrorToken {
-				continue mainLoop
+				return nil, nil, nil, nil, err
 			}
-			out.Write(data)
-			if zt != css.IdentToken {
-				continue mainLoop
+			for {
+				zt, data := zz.Next()
+				if zt == css.ErrorToken {
+					continue mainLoop
+				}
+				if zt == css.IdentToken {
+					writeAnimationName(data)
+					break
+				}
+				out.Write(data)
 			}
-			if string(data) != "media" {
-				continue mainLoop
+		} else if zt == css.AtKeywordToken && string(data) == "@media" {
+			if _, err := tmp.WriteTo(&out); err != nil {
+				return nil, nil, nil, nil, err
 			}
+			braceCount := 0
 			for {
 				zt, data := zz.Next()
 				if zt == css.ErrorToken {
+					if err := reportIssue(KindUnexpectedEOFInMedia); err != nil {
+						return nil, nil, nil, nil, err
+					}
 					continue mainLoop
 				}
 
 				out.Write(data)
 
-				braceCount := 0
 				if zt == css.DelimToken && string(data) == "." {
 					zt, data := zz.Next()
 					if zt == css.ErrorToken {
@@ -140,9 +607,18 @@ mainLoop:
 					}
 				}
 			}
+		} else if zt == css.AtKeywordToken {
+			if _, err := tmp.WriteTo(&out); err != nil {
+				return nil, nil, nil, nil, err
+			}
+			zt, data := zz.Next()
+			if zt == css.ErrorToken {
+				continue mainLoop
+			}
+			out.Write(data)
 		} else if zt == css.DelimToken && string(data) == "." {
 			if _, err := tmp.WriteTo(&out); err != nil {
-				return nil, nil, err
+				return nil, nil, nil, nil, err
 			}
 			zt, data := zz.Next()
 			if zt == css.ErrorToken {
@@ -153,38 +629,417 @@ mainLoop:
 				continue mainLoop
 			}
 			addScopedName(data)
+		} else if zt == css.IdentToken && (string(data) == "animation" || string(data) == "animation-name") {
+			if _, err := tmp.WriteTo(&out); err != nil {
+				return nil, nil, nil, nil, err
+			}
+			for {
+				zt, data := zz.Next()
+				if zt == css.ErrorToken {
+					if err := reportIssue(KindUnexpectedEOFInDeclaration); err != nil {
+						return nil, nil, nil, nil, err
+					}
+					continue mainLoop
+				}
+				if zt == css.IdentToken {
+					writeAnimationName(data)
+				} else {
+					out.Write(data)
+				}
+				if zt == css.SemicolonToken || zt == css.RightBraceToken {
+					break
+				}
+			}
+		} else if zt == css.IdentToken && string(data) == "composes" {
+			// the declaration was already recorded by findComposes; strip it
+			// from the output entirely.
+			for {
+				zt, data := zz.Next()
+				if zt == css.ErrorToken {
+					if err := reportIssue(KindUnexpectedEOFInDeclaration); err != nil {
+						return nil, nil, nil, nil, err
+					}
+					continue mainLoop
+				}
+				if zt == css.SemicolonToken {
+					break
+				}
+				if zt == css.RightBraceToken {
+					out.Write(data)
+					break
+				}
+			}
 		} else {
 			if _, err := tmp.WriteTo(&out); err != nil {
-				return nil, nil, err
+				return nil, nil, nil, nil, err
 			}
 		}
 		tmp.Reset()
 	}
 }
 
+// extractURL extracts the quoted or bare URL out of a `url(...)` token or a
+// bare string token's raw text.
+func extractURL(tok string) string {
+	tok = strings.TrimSpace(tok)
+	tok = strings.TrimPrefix(tok, "url(")
+	tok = strings.TrimSuffix(tok, ")")
+	return strings.Trim(strings.TrimSpace(tok), `"'`)
+}
+
+// resolveImportRule consumes the tokens of a single `@import ...;` rule (the
+// AtKeywordToken itself already consumed by the caller) and returns the text
+// that should replace it in the output: the inlined, recursively resolved
+// stylesheet, wrapped in any `@layer`/media-query conditions the import
+// carried, or the rule's original text unchanged if it names an http(s) URL.
+func resolveImportRule(fsys fs.FS, base string, zz *css.Lexer, visited map[string]bool) (string, error) {
+	var raw bytes.Buffer
+	raw.WriteString("@import")
+
+	var ref, layerName, media string
+	inLayer := false
+	layerDepth := 0
+
+	for {
+		zt, data := zz.Next()
+		if zt == css.ErrorToken {
+			break
+		}
+		raw.Write(data)
+		if zt == css.SemicolonToken {
+			break
+		}
+		switch zt {
+		case css.URLToken:
+			ref = extractURL(string(data))
+		case css.StringToken:
+			if ref == "" {
+				ref = extractURL(string(data))
+			}
+		case css.FunctionToken:
+			if inLayer {
+				layerDepth++
+			} else if string(data) == "layer(" {
+				inLayer = true
+				layerDepth = 1
+			}
+		case css.LeftParenthesisToken:
+			if inLayer {
+				layerDepth++
+			} else if ref != "" {
+				media += string(data)
+			}
+		case css.RightParenthesisToken:
+			if inLayer {
+				layerDepth--
+				if layerDepth == 0 {
+					inLayer = false
+				}
+			} else if ref != "" {
+				media += string(data)
+			}
+		case css.WhitespaceToken:
+			if !inLayer && media != "" {
+				media += " "
+			}
+		default:
+			if inLayer {
+				layerName += string(data)
+			} else if ref != "" {
+				media += string(data)
+			}
+		}
+	}
+
+	if ref == "" || strings.HasPrefix(ref, "http://") || strings.HasPrefix(ref, "https://") {
+		return raw.String(), nil
+	}
+
+	target := path.Join(path.Dir(base), ref)
+	inlined, err := resolveImports(fsys, target, visited)
+	if err != nil {
+		return "", err
+	}
+
+	if layerName != "" {
+		inlined = "@layer " + layerName + " {" + inlined + "}"
+	}
+	if media = strings.TrimSpace(media); media != "" {
+		inlined = "@media " + media + " {" + inlined + "}"
+	}
+	return inlined, nil
+}
+
+// resolveImports reads the stylesheet at p from fsys and inlines any
+// `@import` rules found, resolving nested imports depth-first relative to
+// the importing file's directory. visited tracks every path inlined so far
+// in the current resolution, so a cyclic chain of imports terminates
+// instead of recursing forever; a given path is only inlined once.
+func resolveImports(fsys fs.FS, p string, visited map[string]bool) (string, error) {
+	if visited[p] {
+		return "", nil
+	}
+	visited[p] = true
+
+	data, err := fs.ReadFile(fsys, p)
+	if err != nil {
+		return "", err
+	}
+
+	in := make([]byte, len(data), len(data)+1)
+	copy(in, data)
+	zz := css.NewLexer(parse.NewInputBytes(in))
+
+	var out strings.Builder
+	for {
+		zt, data := zz.Next()
+		if zt == css.ErrorToken {
+			break
+		}
+		if zt == css.AtKeywordToken && string(data) == "@import" {
+			inlined, err := resolveImportRule(fsys, p, zz, visited)
+			if err != nil {
+				return "", err
+			}
+			out.WriteString(inlined)
+			continue
+		}
+		out.Write(data)
+	}
+	return out.String(), nil
+}
+
+// ProcessFS is like Process, but first reads the stylesheet at entry from
+// fsys and inlines any `@import url("./foo.css");` or `@import
+// "./foo.css";` rules it finds, resolving paths relative to the importing
+// file and recursing into imported files so that class names and keyframes
+// defined across multiple files share one deterministic hash bucket.
+// Imports are resolved depth-first with cycle detection; a `layer(...)` or
+// media-query suffix on the `@import` is preserved as a wrapper `@layer`/
+// `@media` block around the inlined content, and a url(...) resolving to an
+// http(s) address is left in the output unchanged rather than being
+// fetched.
+func ProcessFS(fsys fs.FS, entry string) ([]byte, map[string]string, map[string]string, map[string][]string, error) {
+	src, err := resolveImports(fsys, entry, map[string]bool{})
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	return Process(src)
+}
+
 // Collector allows for using one or more rulesets, scoping them
-// deterministically, caching those results, and provides helpers to access the
-// scoped classnames. This is not safe for concurrent access.
+// deterministically, caching those results, and provides helpers to access
+// the scoped classnames. It is safe for concurrent use.
 type Collector struct {
-	rules  map[string]map[string]string
-	styles bytes.Buffer
+	// Minify, when true, minifies each emitted style block and canonicalizes
+	// a ruleset's body before hashing, so that rulesets differing only in
+	// whitespace or comments share one scoped hash and one emitted block.
+	Minify bool
+
+	mu         sync.RWMutex
+	rules      map[string]map[string]string
+	animations map[string]map[string]string
+	externals  map[string]map[string]string
+	canon      map[string]canonEntry
+	warnings   []ProcessError
+	styles     bytes.Buffer
+	fsys       fs.FS
+	fsBase     string
+}
+
+// canonEntry caches the mapping and animations produced for a given
+// canonicalized ruleset, so that cosmetically distinct rulesets sharing a
+// canonical form skip reprocessing and don't emit a second style block. It
+// is keyed separately from Collector.rules/animations, which stay indexed
+// by each caller's original key so later lookups by that same key succeed
+// regardless of Minify.
+type canonEntry struct {
+	mapping    map[string]string
+	animations map[string]string
+}
+
+// WithFS registers the filesystem used to resolve `composes: ... from
+// "<path>"` references against other stylesheets, with paths resolved
+// relative to base. It returns c for chaining.
+func (c *Collector) WithFS(fsys fs.FS, base string) *Collector {
+	c.fsys = fsys
+	c.fsBase = base
+	return c
 }
 
 // Classes returns the classes mapped to their corresponding scoped names.
 func (c *Collector) Classes(rules string) (map[string]string, error) {
-	if m, found := c.rules[rules]; found {
+	return c.classesFrom(rules, "", rules)
+}
+
+// ClassesFS is like Classes, but reads rules from entry within the
+// filesystem registered via WithFS, inlining any `@import` rules found
+// before scoping. It panics if WithFS has not been called.
+func (c *Collector) ClassesFS(entry string) (map[string]string, error) {
+	if c.fsys == nil {
+		panic("cssm: ClassesFS called without WithFS")
+	}
+	p := entry
+	if c.fsBase != "" {
+		p = path.Join(c.fsBase, entry)
+	}
+	c.mu.RLock()
+	m, found := c.rules[p]
+	c.mu.RUnlock()
+	if found {
 		return m, nil
 	}
-	styles, mapping, err := Process(rules)
+	rules, err := resolveImports(c.fsys, p, map[string]bool{})
 	if err != nil {
 		return nil, err
 	}
+	return c.classesFrom(p, path.Dir(p), rules)
+}
+
+// classesFrom processes rules, caching the resulting mapping under key, and
+// merges any composed classes into the returned mapping. dir is the
+// directory `composes: ... from "<path>"` references should be resolved
+// against (the directory of the fs.FS entry rules came from, or "" when
+// rules did not come from the fs.FS, in which case c.fsBase is used
+// instead). It does a fast read-locked lookup for an already-cached key
+// before falling through to a write-locked Process call, double-checking
+// the cache once the write lock is held to avoid duplicate work when
+// multiple goroutines race on the same miss. When Minify is set, rules is
+// canonicalized lock-free before the write lock is taken, and the canonical
+// form is used as a key into c.canon to dedupe cosmetically distinct
+// rulesets that canonicalize the same, so they share one scoped hash and
+// one emitted style block, while c.rules and c.animations stay indexed by
+// the caller's own key.
+func (c *Collector) classesFrom(key, dir, rules string) (map[string]string, error) {
+	c.mu.RLock()
+	m, found := c.rules[key]
+	c.mu.RUnlock()
+	if found {
+		return m, nil
+	}
+
+	processRules := rules
+	var canonKey string
+	if c.Minify {
+		canonical, err := canonicalizeCSS(rules)
+		if err != nil {
+			return nil, err
+		}
+		processRules, canonKey = canonical, canonical
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if m, found := c.rules[key]; found {
+		return m, nil
+	}
+	if c.Minify {
+		if entry, found := c.canon[canonKey]; found {
+			if c.rules == nil {
+				c.rules = map[string]map[string]string{}
+			}
+			if c.animations == nil {
+				c.animations = map[string]map[string]string{}
+			}
+			c.rules[key] = entry.mapping
+			c.animations[key] = entry.animations
+			return entry.mapping, nil
+		}
+	}
+
+	var warnings []ProcessError
+	styles, mapping, animations, composed, err := ProcessWith(processRules, ProcessOptions{Warnings: &warnings})
+	if err != nil {
+		return nil, err
+	}
+	c.warnings = append(c.warnings, warnings...)
+	for name, refs := range composed {
+		scoped, found := mapping[name]
+		if !found {
+			continue
+		}
+		var sb strings.Builder
+		sb.WriteString(scoped)
+		for _, ref := range refs {
+			sb.WriteByte(' ')
+			sb.WriteString(c.resolveComposeRef(dir, ref))
+		}
+		mapping[name] = sb.String()
+	}
+	if c.Minify {
+		if styles, err = minifyCSS(styles); err != nil {
+			return nil, err
+		}
+	}
 	c.styles.Write(styles)
 	c.styles.WriteByte('\n')
 	if c.rules == nil {
 		c.rules = map[string]map[string]string{}
 	}
-	c.rules[rules] = mapping
+	if c.animations == nil {
+		c.animations = map[string]map[string]string{}
+	}
+	c.rules[key] = mapping
+	c.animations[key] = animations
+	if c.Minify {
+		if c.canon == nil {
+			c.canon = map[string]canonEntry{}
+		}
+		c.canon[canonKey] = canonEntry{mapping: mapping, animations: animations}
+	}
+	return mapping, nil
+}
+
+// resolveComposeRef resolves a single flattened composes reference to its
+// final class name. Local and `from global` references are already final;
+// `name@source` references point at another stylesheet and are resolved,
+// relative to dir, against the registered fs.FS, falling back to the bare
+// name if none is registered or the source can't be read.
+func (c *Collector) resolveComposeRef(dir, ref string) string {
+	name, source, found := strings.Cut(ref, "@")
+	if !found {
+		return ref
+	}
+	if c.fsys == nil {
+		return name
+	}
+	mapping, err := c.externalClasses(dir, source)
+	if err != nil {
+		return name
+	}
+	if scoped, found := mapping[name]; found {
+		return scoped
+	}
+	return name
+}
+
+// externalClasses reads and processes the stylesheet at source, resolved
+// against dir (the importing stylesheet's directory within the fs.FS) or,
+// when dir is "", against c.fsBase, caching the result for subsequent
+// lookups.
+func (c *Collector) externalClasses(dir, source string) (map[string]string, error) {
+	p := source
+	switch {
+	case dir != "":
+		p = path.Join(dir, source)
+	case c.fsBase != "":
+		p = path.Join(c.fsBase, source)
+	}
+	if m, found := c.externals[p]; found {
+		return m, nil
+	}
+	data, err := fs.ReadFile(c.fsys, p)
+	if err != nil {
+		return nil, err
+	}
+	_, mapping, _, _, err := Process(string(data))
+	if err != nil {
+		return nil, err
+	}
+	if c.externals == nil {
+		c.externals = map[string]map[string]string{}
+	}
+	c.externals[p] = mapping
 	return mapping, nil
 }
 
@@ -215,14 +1070,39 @@ func (c *Collector) C(rules string, className ...string) gomponents.Node {
 	return h.Class(sb.String())
 }
 
+// A returns the scoped keyframes name for the given animation name, for use
+// in inline styles or JS.
+func (c *Collector) A(rules, name string) string {
+	if _, err := c.Classes(rules); err != nil {
+		panic(err)
+	}
+	c.mu.RLock()
+	scoped, found := c.animations[rules][name]
+	c.mu.RUnlock()
+	if found {
+		return scoped
+	}
+	panic(fmt.Sprintf("no animation found %q", name))
+}
+
 // R returns a gomponents.Node that serves as the class attribute for
 // the special "root" class name.
 func (c *Collector) R(rules string) gomponents.Node {
 	return c.C(rules, "root")
 }
 
+// Warnings returns the malformed constructs collected while processing
+// rulesets registered on this Collector so far.
+func (c *Collector) Warnings() []ProcessError {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.warnings
+}
+
 // Render the collected styles.
 func (c *Collector) Render(w io.Writer) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	if _, err := w.Write([]byte("<style>")); err != nil {
 		return err
 	}
@@ -234,3 +1114,99 @@ func (c *Collector) Render(w io.Writer) error {
 	}
 	return nil
 }
+
+// Freeze snapshots c's current rules and styles into an immutable
+// Stylesheet, suitable for sharing read-only across goroutines without
+// locking. Classes registered on c after Freeze is called are not reflected
+// in the returned Stylesheet.
+func (c *Collector) Freeze() *Stylesheet {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	s := &Stylesheet{
+		rules:      make(map[string]map[string]string, len(c.rules)),
+		animations: make(map[string]map[string]string, len(c.animations)),
+		styles:     append([]byte(nil), c.styles.Bytes()...),
+	}
+	for key, mapping := range c.rules {
+		m := make(map[string]string, len(mapping))
+		for k, v := range mapping {
+			m[k] = v
+		}
+		s.rules[key] = m
+	}
+	for key, mapping := range c.animations {
+		m := make(map[string]string, len(mapping))
+		for k, v := range mapping {
+			m[k] = v
+		}
+		s.animations[key] = m
+	}
+	return s
+}
+
+// Stylesheet is an immutable snapshot of a Collector's rules and styles,
+// produced by (*Collector).Freeze. It exposes the same C, A, R, and Render
+// API as Collector, but does no locking: build one once at program start
+// from a set of component style strings, then share it read-only across
+// request goroutines.
+type Stylesheet struct {
+	rules      map[string]map[string]string
+	animations map[string]map[string]string
+	styles     []byte
+}
+
+// C returns a gomponents.Node that serves as the class attribute for
+// all the provided class names.
+func (s *Stylesheet) C(rules string, className ...string) gomponents.Node {
+	mapping, found := s.rules[rules]
+	if !found {
+		panic(fmt.Sprintf("no ruleset found %q", rules))
+	}
+	if len(className) == 1 {
+		if name, found := mapping[className[0]]; found {
+			return h.Class(name)
+		}
+		panic(fmt.Sprintf("no class found %q", className[0]))
+	}
+	var sb strings.Builder
+	for i, name := range className {
+		if i != 0 {
+			sb.WriteByte(' ')
+		}
+		if mapped, found := mapping[name]; found {
+			sb.WriteString(mapped)
+		} else {
+			panic(fmt.Sprintf("no class found %q", name))
+		}
+	}
+	return h.Class(sb.String())
+}
+
+// A returns the scoped keyframes name for the given animation name, for use
+// in inline styles or JS.
+func (s *Stylesheet) A(rules, name string) string {
+	if scoped, found := s.animations[rules][name]; found {
+		return scoped
+	}
+	panic(fmt.Sprintf("no animation found %q", name))
+}
+
+// R returns a gomponents.Node that serves as the class attribute for
+// the special "root" class name.
+func (s *Stylesheet) R(rules string) gomponents.Node {
+	return s.C(rules, "root")
+}
+
+// Render writes the frozen styles.
+func (s *Stylesheet) Render(w io.Writer) error {
+	if _, err := w.Write([]byte("<style>")); err != nil {
+		return err
+	}
+	if _, err := w.Write(s.styles); err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte("</style>")); err != nil {
+		return err
+	}
+	return nil
+}
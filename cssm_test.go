@@ -2,7 +2,10 @@ package cssm
 
 import (
 	"bytes"
+	"strings"
+	"sync"
 	"testing"
+	"testing/fstest"
 )
 
 type matchableCSS struct {
@@ -18,11 +21,12 @@ func newMatchableCSS(canMatch bool, value []byte) matchableCSS {
 }
 
 var testCasesCSSModules = []struct {
-	name                  string
-	payload               string
-	expectedCSSModules    matchableCSS
-	expectedScopedClasses []string
-	expectedError         string
+	name                     string
+	payload                  string
+	expectedCSSModules       matchableCSS
+	expectedScopedClasses    []string
+	expectedScopedAnimations []string
+	expectedError            string
 }{
 	{
 		name:                  "ValidCSSModules",
@@ -79,32 +83,11 @@ font-size: large;
 		payload: `#test-class {color: red; font-size: medium}`,
 	},
 	{
-		name: "ValidCSSModules_Another@declarationsSupport",
-		expectedCSSModules: newMatchableCSS(true, []byte(`@import url("path/to/styles.css");
-@keyframes myAnimation {
-	from {
-		background-color: red;
-	}
-	to {
-		background-color: blue;
-	}
-}
-@keyframes anotherAnimation {
-	0% {
-		background-color: green;
-	}
-	10% {
-		background-color: red;
-	}
-	90% {
-		background-color: black;
-	}
-	100% {
-		background-color: purple;
-	}
-}`)),
-		expectedScopedClasses: nil,
-		expectedError:         "",
+		name:                     "ValidCSSModules_Another@declarationsSupport",
+		expectedCSSModules:       newMatchableCSS(false, nil),
+		expectedScopedClasses:    nil,
+		expectedScopedAnimations: []string{"myAnimation", "anotherAnimation"},
+		expectedError:            "",
 
 		payload: `@import url("path/to/styles.css");
 @keyframes myAnimation {
@@ -170,6 +153,32 @@ font-size: large;
 
 		payload: `. test-class :hover { color:green; font-size: medium; }`,
 	},
+	{
+		name:                     "ValidCSSModules_KeyframesShorthand",
+		expectedCSSModules:       newMatchableCSS(false, nil),
+		expectedScopedClasses:    []string{"loader"},
+		expectedScopedAnimations: []string{"spin"},
+		expectedError:            "",
+
+		payload: `@keyframes spin {
+	from { opacity: 0; }
+	to { opacity: 1; }
+}
+.loader {
+	animation: 2s linear infinite spin;
+}`,
+	},
+	{
+		name: "ValidCSSModules_GlobalKeyframesPreservesName",
+		expectedCSSModules: newMatchableCSS(true,
+			[]byte(` @keyframes spin { from { opacity: 0; } to { opacity: 1; } }`),
+		),
+		expectedScopedClasses:    nil,
+		expectedScopedAnimations: nil,
+		expectedError:            "",
+
+		payload: `:global {@keyframes spin { from { opacity: 0; } to { opacity: 1; } }}`,
+	},
 }
 
 func TestProcess(t *testing.T) {
@@ -177,7 +186,7 @@ func TestProcess(t *testing.T) {
 		tc := testCasesCSSModules[i]
 		t.Run(tc.name, func(t *testing.T) {
 			t.Parallel()
-			css, scopedClasses, err := Process(tc.payload)
+			css, scopedClasses, scopedAnimations, _, err := Process(tc.payload)
 			if err != nil {
 				if err.Error() != tc.expectedError {
 					t.Errorf("unexpected error value: expected %q got %q", tc.expectedError, err.Error())
@@ -202,6 +211,447 @@ func TestProcess(t *testing.T) {
 					return
 				}
 			}
+			for i := range tc.expectedScopedAnimations {
+				esa := tc.expectedScopedAnimations[i]
+				if _, ok := scopedAnimations[esa]; !ok {
+					t.Errorf("unexpected scopedAnimations value absence: expected to have %q inside of it, got %q map", esa, scopedAnimations)
+					return
+				}
+			}
 		})
 	}
 }
+
+func TestProcessComposes(t *testing.T) {
+	css, mapping, _, composed, err := Process(`.btn {
+	color: blue;
+}
+.primary {
+	composes: btn;
+	color: red;
+}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(css), "composes") {
+		t.Errorf("expected composes declaration to be stripped, got %q", css)
+	}
+	btn, ok := mapping["btn"]
+	if !ok {
+		t.Fatal("expected btn to be scoped")
+	}
+	if got := composed["primary"]; len(got) != 1 || got[0] != btn {
+		t.Errorf("expected primary to compose %q, got %v", btn, got)
+	}
+}
+
+func TestProcessComposesGlobal(t *testing.T) {
+	_, _, _, composed, err := Process(`.primary {
+	composes: btn base from global;
+}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"btn", "base"}
+	got := composed["primary"]
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+func TestCollectorComposesTransitive(t *testing.T) {
+	c := &Collector{}
+	mapping, err := c.Classes(`.base {
+	color: black;
+}
+.btn {
+	composes: base;
+}
+.primary {
+	composes: btn;
+}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Fields(mapping["primary"])
+	for _, want := range []string{"primary_", "btn_", "base_"} {
+		found := false
+		for _, part := range parts {
+			if strings.HasPrefix(part, want) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("expected primary's classes %v to include one prefixed %q", parts, want)
+		}
+	}
+}
+
+func TestCollectorComposesCycle(t *testing.T) {
+	c := &Collector{}
+	if _, err := c.Classes(`.a {
+	composes: b;
+}
+.b {
+	composes: a;
+}`); err == nil {
+		t.Error("expected a cycle error, got nil")
+	}
+}
+
+func TestProcessWithStrict(t *testing.T) {
+	_, _, _, _, err := ProcessWith(`:global {.test-class { color: red; font-size: large; }`, ProcessOptions{Strict: true})
+	if err == nil {
+		t.Fatal("expected an error, got nil")
+	}
+	pe, ok := err.(ProcessError)
+	if !ok {
+		t.Fatalf("expected a ProcessError, got %T: %v", err, err)
+	}
+	if pe.Kind != KindUnterminatedBlock {
+		t.Errorf("expected KindUnterminatedBlock, got %v", pe.Kind)
+	}
+	if pe.Line == 0 {
+		t.Errorf("expected a non-zero line, got %d", pe.Line)
+	}
+}
+
+func TestProcessWithWarnings(t *testing.T) {
+	var warnings []ProcessError
+	css, _, _, _, err := ProcessWith(`:global {.test-class { color: red; font-size: large; }`, ProcessOptions{Warnings: &warnings})
+	if err != nil {
+		t.Fatalf("expected lenient handling to return no error, got %v", err)
+	}
+	if string(css) != " .test-class { color: red; font-size: large; }" {
+		t.Errorf("expected lenient output to match Process, got %q", css)
+	}
+	if len(warnings) != 1 {
+		t.Fatalf("expected a single warning, got %d: %v", len(warnings), warnings)
+	}
+	if warnings[0].Kind != KindUnterminatedBlock {
+		t.Errorf("expected KindUnterminatedBlock, got %v", warnings[0].Kind)
+	}
+}
+
+func TestCollectorWarnings(t *testing.T) {
+	c := &Collector{}
+	if _, err := c.Classes(`:global {.test-class { color: red; font-size: large; }`); err != nil {
+		t.Fatal(err)
+	}
+	warnings := c.Warnings()
+	if len(warnings) != 1 {
+		t.Fatalf("expected a single warning, got %d: %v", len(warnings), warnings)
+	}
+	if warnings[0].Kind != KindUnterminatedBlock {
+		t.Errorf("expected KindUnterminatedBlock, got %v", warnings[0].Kind)
+	}
+}
+
+func TestProcessFSTwoFileImport(t *testing.T) {
+	fsys := fstest.MapFS{
+		"base.css":  &fstest.MapFile{Data: []byte(`.shared { color: green; }`)},
+		"entry.css": &fstest.MapFile{Data: []byte(`@import "./base.css"; .primary { color: red; }`)},
+	}
+	css, mapping, _, _, err := ProcessFS(fsys, "entry.css")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := mapping["shared"]; !ok {
+		t.Errorf("expected shared to be scoped, got %q mapping %v", css, mapping)
+	}
+	if _, ok := mapping["primary"]; !ok {
+		t.Errorf("expected primary to be scoped, got %q mapping %v", css, mapping)
+	}
+	if !strings.Contains(string(css), mapping["shared"]) {
+		t.Errorf("expected output to contain scoped shared class, got %q", css)
+	}
+}
+
+func TestProcessFSCyclicImport(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a.css": &fstest.MapFile{Data: []byte(`@import "./b.css"; .a { color: red; }`)},
+		"b.css": &fstest.MapFile{Data: []byte(`@import "./a.css"; .b { color: blue; }`)},
+	}
+	_, mapping, _, _, err := ProcessFS(fsys, "a.css")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := mapping["a"]; !ok {
+		t.Errorf("expected a to be scoped, got %v", mapping)
+	}
+	if _, ok := mapping["b"]; !ok {
+		t.Errorf("expected b to be scoped, got %v", mapping)
+	}
+}
+
+func TestProcessFSMediaQueryImport(t *testing.T) {
+	fsys := fstest.MapFS{
+		"base.css":  &fstest.MapFile{Data: []byte(`.shared { color: green; }`)},
+		"entry.css": &fstest.MapFile{Data: []byte(`@import "./base.css" screen;`)},
+	}
+	css, _, _, _, err := ProcessFS(fsys, "entry.css")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(css), "@media screen {") {
+		t.Errorf("expected output to be wrapped in an @media screen block, got %q", css)
+	}
+}
+
+func TestProcessFSParenthesizedMediaQueryImport(t *testing.T) {
+	fsys := fstest.MapFS{
+		"base.css":  &fstest.MapFile{Data: []byte(`.shared { color: green; }`)},
+		"entry.css": &fstest.MapFile{Data: []byte(`@import "./base.css" screen and (min-width: 600px);`)},
+	}
+	css, _, _, _, err := ProcessFS(fsys, "entry.css")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(css), "@media screen and (min-width: 600px) {") {
+		t.Errorf("expected output to be wrapped in a parenthesized @media block, got %q", css)
+	}
+}
+
+func TestProcessFSRemoteImportLeftIntact(t *testing.T) {
+	fsys := fstest.MapFS{
+		"entry.css": &fstest.MapFile{Data: []byte(`@import url("https://example.com/foo.css");`)},
+	}
+	css, _, _, _, err := ProcessFS(fsys, "entry.css")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(css), `https://example.com/foo.css`) {
+		t.Errorf("expected remote import to be left intact, got %q", css)
+	}
+}
+
+func TestCollectorClassesFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"base.css":  &fstest.MapFile{Data: []byte(`.shared { color: green; }`)},
+		"entry.css": &fstest.MapFile{Data: []byte(`@import "./base.css"; .primary { color: red; }`)},
+	}
+	c := (&Collector{}).WithFS(fsys, ".")
+	mapping, err := c.ClassesFS("entry.css")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := mapping["shared"]; !ok {
+		t.Errorf("expected shared to be scoped, got %v", mapping)
+	}
+}
+
+func TestCollectorClassesConcurrent(t *testing.T) {
+	c := &Collector{}
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			mapping, err := c.Classes(`.test-class { color: red; }`)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if _, ok := mapping["test-class"]; !ok {
+				t.Error("expected test-class to be scoped")
+			}
+		}()
+	}
+	wg.Wait()
+	if len(c.rules) != 1 {
+		t.Errorf("expected a single cached ruleset, got %d", len(c.rules))
+	}
+}
+
+func TestCollectorFreeze(t *testing.T) {
+	c := &Collector{}
+	if _, err := c.Classes(`@keyframes spin {
+	from { opacity: 0; }
+	to { opacity: 1; }
+}
+.loader {
+	animation: 2s linear infinite spin;
+}`); err != nil {
+		t.Fatal(err)
+	}
+
+	s := c.Freeze()
+	node := s.C(`@keyframes spin {
+	from { opacity: 0; }
+	to { opacity: 1; }
+}
+.loader {
+	animation: 2s linear infinite spin;
+}`, "loader")
+	var sb strings.Builder
+	if err := node.Render(&sb); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(sb.String(), "loader_") {
+		t.Errorf("expected rendered class attribute to contain scoped loader class, got %q", sb.String())
+	}
+
+	var rendered strings.Builder
+	if err := s.Render(&rendered); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(rendered.String(), "<style>") {
+		t.Errorf("expected Render output to be wrapped in a <style> tag, got %q", rendered.String())
+	}
+}
+
+func TestCollectorMinifyDedupe(t *testing.T) {
+	c := &Collector{Minify: true}
+	a, err := c.Classes(`.test-class {
+	color: red;
+	font-size: large;
+}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := c.Classes(`.test-class{color:red;font-size:large;}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a["test-class"] != b["test-class"] {
+		t.Errorf("expected both rulesets to share a scoped classname, got %q and %q", a["test-class"], b["test-class"])
+	}
+	if len(c.rules) != 2 {
+		t.Errorf("expected one cache entry per caller key, got %d", len(c.rules))
+	}
+	if len(c.canon) != 1 {
+		t.Errorf("expected a single canonical dedupe entry, got %d", len(c.canon))
+	}
+	if n := strings.Count(c.styles.String(), ".test-class"); n != 1 {
+		t.Errorf("expected a single emitted style block, got %d occurrences in %q", n, c.styles.String())
+	}
+}
+
+func TestCollectorMinifyDedupeLayer(t *testing.T) {
+	c := &Collector{Minify: true}
+	a, err := c.Classes(`@layer foo {
+	.test-class {
+		color: red;
+	}
+}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := c.Classes(`@layer foo{.test-class{color:red;}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a["test-class"] != b["test-class"] {
+		t.Errorf("expected both @layer-wrapped rulesets to share a scoped classname, got %q and %q", a["test-class"], b["test-class"])
+	}
+	if len(c.canon) != 1 {
+		t.Errorf("expected a single canonical dedupe entry, got %d", len(c.canon))
+	}
+	if n := strings.Count(c.styles.String(), ".test-class"); n != 1 {
+		t.Errorf("expected a single emitted style block, got %d occurrences in %q", n, c.styles.String())
+	}
+}
+
+func TestCollectorMinifyOutput(t *testing.T) {
+	c := &Collector{Minify: true}
+	if _, err := c.Classes(`.test-class {
+	color: red;
+	font-size: large;
+}`); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(c.styles.String(), "\n\t") {
+		t.Errorf("expected minified output with no indentation, got %q", c.styles.String())
+	}
+}
+
+func TestCollectorMinifyA(t *testing.T) {
+	c := &Collector{Minify: true}
+	rules := `@keyframes spin {
+	from { opacity: 0; }
+	to { opacity: 1; }
+}
+.loader {
+	animation: 2s linear infinite spin;
+}`
+	if _, err := c.Classes(rules); err != nil {
+		t.Fatal(err)
+	}
+	if scoped := c.A(rules, "spin"); !strings.Contains(scoped, "spin_") {
+		t.Errorf("expected scoped animation name, got %q", scoped)
+	}
+}
+
+func TestCollectorMinifyFreeze(t *testing.T) {
+	c := &Collector{Minify: true}
+	rules := `.test-class {
+	color: red;
+}`
+	if _, err := c.Classes(rules); err != nil {
+		t.Fatal(err)
+	}
+	s := c.Freeze()
+	node := s.C(rules, "test-class")
+	var sb strings.Builder
+	if err := node.Render(&sb); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(sb.String(), "test-class_") {
+		t.Errorf("expected rendered class attribute to contain scoped test-class, got %q", sb.String())
+	}
+}
+
+func TestCollectorMinifyClassesFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"entry.css": &fstest.MapFile{Data: []byte(`.primary { color: red; }`)},
+	}
+	c := (&Collector{Minify: true}).WithFS(fsys, ".")
+	first, err := c.ClassesFS("entry.css")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := c.ClassesFS("entry.css")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first["primary"] != second["primary"] {
+		t.Errorf("expected repeated ClassesFS calls to hit the cache, got %q and %q", first["primary"], second["primary"])
+	}
+	if len(c.rules) != 1 {
+		t.Errorf("expected a single cache entry keyed by fs path, got %d", len(c.rules))
+	}
+}
+
+func TestCollectorComposesExternal(t *testing.T) {
+	fsys := fstest.MapFS{
+		"other.css": &fstest.MapFile{Data: []byte(`.shared { color: green; }`)},
+	}
+	c := (&Collector{}).WithFS(fsys, ".")
+	mapping, err := c.Classes(`.primary {
+	composes: shared from "other.css";
+}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(mapping["primary"], "shared_") {
+		t.Errorf("expected primary to compose the external shared class, got %q", mapping["primary"])
+	}
+}
+
+func TestCollectorClassesFSComposesNestedDir(t *testing.T) {
+	fsys := fstest.MapFS{
+		"components/sibling.css": &fstest.MapFile{Data: []byte(`.shared { color: green; }`)},
+		"components/entry.css": &fstest.MapFile{Data: []byte(`.primary {
+	composes: shared from "./sibling.css";
+}`)},
+	}
+	c := (&Collector{}).WithFS(fsys, ".")
+	mapping, err := c.ClassesFS("components/entry.css")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(mapping["primary"], "shared_") {
+		t.Errorf("expected primary to compose the sibling shared class, got %q", mapping["primary"])
+	}
+}